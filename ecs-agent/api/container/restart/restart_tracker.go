@@ -14,23 +14,179 @@
 package restart
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"time"
 
 	apicontainerstatus "github.com/aws/amazon-ecs-agent/ecs-agent/api/container/status"
 )
 
+// RestartPolicyName identifies the restart behavior to apply when a container exits,
+// mirroring the named restart policies supported by Docker and Podman.
+type RestartPolicyName string
+
+const (
+	RestartPolicyNameNo            RestartPolicyName = "no"
+	RestartPolicyNameAlways        RestartPolicyName = "always"
+	RestartPolicyNameOnFailure     RestartPolicyName = "on-failure"
+	RestartPolicyNameUnlessStopped RestartPolicyName = "unless-stopped"
+)
+
 // RestartPolicy represents a policy that contains key information considered when
 // deciding whether or not a container should be restarted after it has exited.
 type RestartPolicy struct {
-	Enabled            bool          `json:"enabled"`
-	IgnoredExitCodes   []int         `json:"ignoredExitCodes"`
+	Name             RestartPolicyName `json:"name"`
+	IgnoredExitCodes []int             `json:"ignoredExitCodes"`
+	// UnrecoverableExitCodes marks exit codes (e.g. container config errors like 125-127,
+	// or application-specific "do not retry" codes) that permanently disable restarts for
+	// the container, rather than just skipping this one restart.
+	UnrecoverableExitCodes []int `json:"unrecoverableExitCodes"`
+	// Enabled is deprecated in favor of Name. It is only consulted when Name is empty:
+	// by UnmarshalJSON, to interpret restart policies persisted before Name was
+	// introduced, and by ShouldRestart, for policies constructed without a Name.
+	Enabled bool `json:"enabled"`
+	// AttemptResetPeriod is how long a container must stay running before
+	// RestartCount is decayed back to 0, so that a transient crash doesn't
+	// count against a later, unrelated crash loop. It only triggers decay and
+	// never blocks a restart outright — a container that keeps crashing faster
+	// than this period must still be governed by MaximumRetryCount and backoff.
 	AttemptResetPeriod time.Duration `json:"attemptResetPeriod"`
+	// MaximumRetryCount caps the number of times a container will be restarted,
+	// mirroring Docker's "on-failure:N" restart policy. A value of 0 means
+	// unlimited restarts.
+	MaximumRetryCount int `json:"maximumRetryCount"`
+	// BackoffInitial is the base of the exponential backoff: the delay is
+	// BackoffInitial * 2^RestartCount, capped at BackoffMax. ShouldRestart only
+	// waits for it once a restart has been recorded, so the first restart is not delayed.
+	BackoffInitial time.Duration `json:"backoffInitial"`
+	// BackoffMax caps the exponential backoff delay between restart attempts.
+	BackoffMax time.Duration `json:"backoffMax"`
+	// BackoffJitter randomizes the backoff delay by up to this fraction in either
+	// direction (e.g. 0.25 = +/-25%) to avoid thundering-herd restarts across a fleet.
+	BackoffJitter float64 `json:"backoffJitter"`
+}
+
+// UnmarshalJSON restores RestartPolicy from JSON, translating the legacy Enabled
+// bool into the equivalent Name for state persisted before Name was introduced.
+func (rp *RestartPolicy) UnmarshalJSON(data []byte) error {
+	type restartPolicyAlias RestartPolicy
+	aux := (*restartPolicyAlias)(rp)
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	if rp.Name == "" {
+		if rp.Enabled {
+			rp.Name = RestartPolicyNameAlways
+		} else {
+			rp.Name = RestartPolicyNameNo
+		}
+	}
+	return nil
+}
+
+// MarshalJSON persists RestartPolicy with Enabled kept in sync with Name, so an older
+// binary that still reads the legacy Enabled field directly (e.g. during a fleet
+// rollback) sees the correct value during the version-skew window Enabled exists for.
+func (rp RestartPolicy) MarshalJSON() ([]byte, error) {
+	type restartPolicyAlias RestartPolicy
+	aux := restartPolicyAlias(rp)
+	if rp.Name != "" {
+		aux.Enabled = rp.Name != RestartPolicyNameNo
+	}
+	return json.Marshal(aux)
+}
+
+// RestartReason is a machine-readable label for why a restart decision was made,
+// suitable for surfacing to customers (e.g. via ACS container state change events)
+// without parsing a free-form message string.
+type RestartReason string
+
+const (
+	ReasonPolicyDisabled         RestartReason = "PolicyDisabled"
+	ReasonDesiredStopped         RestartReason = "DesiredStopped"
+	ReasonExitCodeUnknown        RestartReason = "ExitCodeUnknown"
+	ReasonExitCodeIgnored        RestartReason = "ExitCodeIgnored"
+	ReasonOnFailureExitZero      RestartReason = "OnFailureExitZero"
+	ReasonManuallyStopped        RestartReason = "ManuallyStopped"
+	ReasonMaxRetriesExceeded     RestartReason = "MaxRetriesExceeded"
+	ReasonAttemptResetNotElapsed RestartReason = "AttemptResetNotElapsed"
+	ReasonBackoffDelay           RestartReason = "BackoffDelay"
+	ReasonUnrecoverableError     RestartReason = "UnrecoverableError"
+	ReasonSpotInterruption       RestartReason = "SpotInterruption"
+	ReasonWillRestart            RestartReason = "WillRestart"
+)
+
+// RestartDecision records the outcome of a single ShouldRestart evaluation. It pairs a
+// machine-readable Reason with the human-readable Message returned by ShouldRestart, so
+// that a decision can be surfaced to customers as structured telemetry instead of an
+// opaque string.
+type RestartDecision struct {
+	ShouldRestart bool
+	Reason        RestartReason
+	Message       string
+	Timestamp     time.Time
+}
+
+// maxRestartDecisionHistory bounds how many past decisions a RestartTracker retains.
+const maxRestartDecisionHistory = 20
+
+// TerminationNoticeProvider reports whether the host is about to be reclaimed, e.g. due to
+// an EC2 Spot interruption or an ASG lifecycle terminating notice, so that RestartTracker
+// can skip restarting containers that would just be killed again moments later.
+type TerminationNoticeProvider interface {
+	IsTerminating() bool
 }
 
 type RestartTracker struct {
-	RestartCount  int `json:"restartCount,omitempty"`
-	restartPolicy RestartPolicy
+	RestartCount int `json:"restartCount,omitempty"`
+	// ManuallyStopped records that the container was deliberately stopped (e.g. by
+	// the agent's shutdown or stop API paths), so an "unless-stopped" restart
+	// policy knows not to restart it.
+	ManuallyStopped           bool `json:"manuallyStopped,omitempty"`
+	restartPolicy             RestartPolicy
+	lastRestartAt             time.Time
+	history                   []RestartDecision
+	terminationNoticeProvider TerminationNoticeProvider
+	// Unrecoverable is set once the container exits with an UnrecoverableExitCodes entry,
+	// and permanently short-circuits ShouldRestart from then on. It must survive the
+	// tracker being reloaded after an agent restart, so it is persisted like RestartCount
+	// and ManuallyStopped rather than kept as unexported in-memory-only state.
+	Unrecoverable bool `json:"unrecoverable,omitempty"`
+}
+
+// SetTerminationNoticeProvider configures the source RestartTracker consults to find out
+// whether the host is terminating. If never set, ShouldRestart does not consider
+// termination notices at all.
+func (rt *RestartTracker) SetTerminationNoticeProvider(provider TerminationNoticeProvider) {
+	rt.terminationNoticeProvider = provider
+}
+
+// History returns the most recent restart decisions made by ShouldRestart, oldest first,
+// up to maxRestartDecisionHistory entries. ShouldRestart also returns its decision
+// directly to the caller; History is for auditing the decisions leading up to it.
+func (rt *RestartTracker) History() []RestartDecision {
+	history := make([]RestartDecision, len(rt.history))
+	copy(history, rt.history)
+	return history
+}
+
+// recordDecision appends a RestartDecision to the tracker's history, trimming the oldest
+// entries once the history exceeds maxRestartDecisionHistory, and returns the decision so
+// ShouldRestart can hand it straight to its caller.
+func (rt *RestartTracker) recordDecision(shouldRestart bool, reason RestartReason, message string) RestartDecision {
+	decision := RestartDecision{
+		ShouldRestart: shouldRestart,
+		Reason:        reason,
+		Message:       message,
+		Timestamp:     time.Now(),
+	}
+	rt.history = append(rt.history, decision)
+	if len(rt.history) > maxRestartDecisionHistory {
+		rt.history = rt.history[len(rt.history)-maxRestartDecisionHistory:]
+	}
+	return decision
 }
 
 func NewRestartTracker(restartPolicy RestartPolicy) *RestartTracker {
@@ -49,29 +205,119 @@ func (rt *RestartTracker) GetRestartCount() int {
 // occur or restart the container. It is expected to receive a startedAt time from the container runtime.
 func (rt *RestartTracker) RecordRestart() {
 	rt.RestartCount += 1
+	rt.lastRestartAt = time.Now()
+}
+
+// maxBackoffDelay is the ceiling applied to NextRestartDelay when BackoffMax is left at
+// its zero value or set above it. Without it, a container with a very large RestartCount can drive
+// math.Pow's result to +Inf, which converts to a large *negative* time.Duration and
+// silently disables the backoff check entirely.
+const maxBackoffDelay = 24 * time.Hour
+
+// NextRestartDelay returns how long to wait before the next restart attempt,
+// applying exponential backoff (doubling per RestartCount, capped at BackoffMax, or at
+// maxBackoffDelay if BackoffMax is not configured or exceeds it) with up to BackoffJitter of random
+// jitter applied in either direction. It returns 0 if BackoffInitial is not configured.
+func (rt *RestartTracker) NextRestartDelay() time.Duration {
+	if rt.restartPolicy.BackoffInitial <= 0 {
+		return 0
+	}
+	backoffCap := rt.restartPolicy.BackoffMax
+	if backoffCap <= 0 || backoffCap > maxBackoffDelay {
+		backoffCap = maxBackoffDelay
+	}
+	delay := float64(rt.restartPolicy.BackoffInitial) * math.Pow(2, float64(rt.RestartCount))
+	if delay > float64(backoffCap) {
+		delay = float64(backoffCap)
+	}
+	if rt.restartPolicy.BackoffJitter > 0 {
+		sign := 1.0
+		if rand.Float64() < 0.5 {
+			sign = -1.0
+		}
+		delay *= 1 + rand.Float64()*rt.restartPolicy.BackoffJitter*sign
+	}
+	return time.Duration(delay)
+}
+
+// RecordManualStop marks the container as having been deliberately stopped, so that
+// an "unless-stopped" restart policy will not restart it. Callers such as the agent's
+// shutdown or stop API paths are expected to call this before the container exits.
+func (rt *RestartTracker) RecordManualStop() {
+	rt.ManuallyStopped = true
 }
 
-// ShouldRestart returns whether the container should restart and a reason string
-// explaining why not.
+// ShouldRestart evaluates the container's exit against its restart policy and returns the
+// resulting RestartDecision, so a caller can forward it (e.g. as an ACS container state
+// change event) without having to parse a free-form reason string.
 func (rt *RestartTracker) ShouldRestart(exitCode *int, startedAt time.Time,
-	desiredStatus apicontainerstatus.ContainerStatus) (bool, string) {
-	if !rt.restartPolicy.Enabled {
-		return false, "restart policy is not enabled"
+	desiredStatus apicontainerstatus.ContainerStatus) RestartDecision {
+	if rt.Unrecoverable {
+		return rt.recordDecision(false, ReasonUnrecoverableError,
+			"container previously exited with an unrecoverable exit code and will not be restarted")
+	}
+	// Name is normally set by UnmarshalJSON from the legacy Enabled field, but fall back
+	// to the same translation here for callers that construct a RestartPolicy directly
+	// (e.g. RestartPolicy{Enabled: true}) without going through JSON.
+	policyName := rt.restartPolicy.Name
+	if policyName == "" {
+		if rt.restartPolicy.Enabled {
+			policyName = RestartPolicyNameAlways
+		} else {
+			policyName = RestartPolicyNameNo
+		}
+	}
+	if policyName == RestartPolicyNameNo {
+		return rt.recordDecision(false, ReasonPolicyDisabled, "restart policy is not enabled")
+	}
+	if rt.terminationNoticeProvider != nil && rt.terminationNoticeProvider.IsTerminating() {
+		return rt.recordDecision(false, ReasonSpotInterruption, "instance is terminating")
 	}
 	if desiredStatus == apicontainerstatus.ContainerStopped {
-		return false, "container's desired status is stopped"
+		return rt.recordDecision(false, ReasonDesiredStopped, "container's desired status is stopped")
 	}
 	if exitCode == nil {
-		return false, "exit code is nil"
+		return rt.recordDecision(false, ReasonExitCodeUnknown, "exit code is nil")
+	}
+	for _, unrecoverableCode := range rt.restartPolicy.UnrecoverableExitCodes {
+		if unrecoverableCode == *exitCode {
+			rt.Unrecoverable = true
+			return rt.recordDecision(false, ReasonUnrecoverableError, fmt.Sprintf("exit code %d is unrecoverable", *exitCode))
+		}
 	}
 	for _, ignoredCode := range rt.restartPolicy.IgnoredExitCodes {
 		if ignoredCode == *exitCode {
-			return false, fmt.Sprintf("exit code %d should be ignored", *exitCode)
+			return rt.recordDecision(false, ReasonExitCodeIgnored, fmt.Sprintf("exit code %d should be ignored", *exitCode))
 		}
 	}
-	if time.Since(startedAt) < rt.restartPolicy.AttemptResetPeriod {
-		return false, "attempt reset period has not elapsed"
+	switch policyName {
+	case RestartPolicyNameOnFailure:
+		if *exitCode == 0 {
+			return rt.recordDecision(false, ReasonOnFailureExitZero, "restart policy is on-failure and exit code is 0")
+		}
+	case RestartPolicyNameUnlessStopped:
+		if rt.ManuallyStopped {
+			return rt.recordDecision(false, ReasonManuallyStopped, "container was manually stopped")
+		}
+	}
+	// AttemptResetPeriod is a decay trigger, not a precondition for restarting: once a
+	// container has stayed running at least that long, RestartCount decays back to 0 (even
+	// if it was previously at the cap, so a later long-lived run still earns a fresh
+	// attempt). It must never block a restart outright — doing so would permanently trap a
+	// fast crash loop, which is exactly the scenario MaximumRetryCount and backoff below
+	// exist to govern.
+	if rt.restartPolicy.AttemptResetPeriod > 0 && time.Since(startedAt) >= rt.restartPolicy.AttemptResetPeriod {
+		rt.RestartCount = 0
+	}
+	if rt.restartPolicy.MaximumRetryCount > 0 && rt.RestartCount >= rt.restartPolicy.MaximumRetryCount {
+		return rt.recordDecision(false, ReasonMaxRetriesExceeded,
+			fmt.Sprintf("maximum restart count %d reached", rt.restartPolicy.MaximumRetryCount))
+	}
+	if !rt.lastRestartAt.IsZero() {
+		if delay := rt.NextRestartDelay(); time.Since(rt.lastRestartAt) < delay {
+			return rt.recordDecision(false, ReasonBackoffDelay, "waiting for backoff delay")
+		}
 	}
 
-	return true, ""
+	return rt.recordDecision(true, ReasonWillRestart, "")
 }
@@ -17,6 +17,7 @@
 package restart
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -26,10 +27,11 @@ import (
 )
 
 func TestShouldRestart(t *testing.T) {
-	rt := NewRestartTracker(RestartPolicy{Enabled: false, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute})
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameNo, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute})
 	testCases := []struct {
 		name           string
 		rp             RestartPolicy
+		restartCount   int
 		exitCode       int
 		startedAt      time.Time
 		desiredStatus  apicontainerstatus.ContainerStatus
@@ -38,7 +40,7 @@ func TestShouldRestart(t *testing.T) {
 	}{
 		{
 			name:           "restart policy disabled",
-			rp:             RestartPolicy{Enabled: false, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
+			rp:             RestartPolicy{Name: RestartPolicyNameNo, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
 			exitCode:       1,
 			startedAt:      time.Now().Add(2 * time.Minute),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
@@ -47,7 +49,7 @@ func TestShouldRestart(t *testing.T) {
 		},
 		{
 			name:           "ignored exit code",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
 			exitCode:       0,
 			startedAt:      time.Now().Add(2 * time.Minute),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
@@ -56,7 +58,7 @@ func TestShouldRestart(t *testing.T) {
 		},
 		{
 			name:           "non ignored exit code",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
 			exitCode:       1,
 			startedAt:      time.Now().Add(-2 * time.Minute),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
@@ -65,7 +67,7 @@ func TestShouldRestart(t *testing.T) {
 		},
 		{
 			name:           "nil exit code",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute},
 			exitCode:       -1,
 			startedAt:      time.Now().Add(2 * time.Minute),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
@@ -74,7 +76,7 @@ func TestShouldRestart(t *testing.T) {
 		},
 		{
 			name:           "desired status stopped",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
 			exitCode:       1,
 			startedAt:      time.Now().Add(2 * time.Minute),
 			desiredStatus:  apicontainerstatus.ContainerStopped,
@@ -82,28 +84,87 @@ func TestShouldRestart(t *testing.T) {
 			expectedReason: "container's desired status is stopped",
 		},
 		{
-			name:           "attempt reset period not elapsed",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			name:           "attempt reset period not elapsed still restarts without decaying",
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			restartCount:   2,
 			exitCode:       1,
 			startedAt:      time.Now(),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
-			expected:       false,
-			expectedReason: "attempt reset period has not elapsed",
+			expected:       true,
+			expectedReason: "",
 		},
 		{
-			name:           "attempt reset period not elapsed within one second",
-			rp:             RestartPolicy{Enabled: true, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			name:           "attempt reset period not elapsed within one second still restarts",
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, AttemptResetPeriod: time.Minute},
+			restartCount:   2,
 			exitCode:       1,
 			startedAt:      time.Now().Add(-time.Second * 59),
 			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       true,
+			expectedReason: "",
+		},
+		{
+			name:           "maximum retry count reached",
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, MaximumRetryCount: 3},
+			restartCount:   3,
+			exitCode:       1,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       false,
+			expectedReason: "maximum restart count 3 reached",
+		},
+		{
+			name:           "maximum retry count of zero is unlimited",
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}, MaximumRetryCount: 0},
+			restartCount:   1000,
+			exitCode:       1,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       true,
+			expectedReason: "",
+		},
+		{
+			name:           "always restarts on zero exit code",
+			rp:             RestartPolicy{Name: RestartPolicyNameAlways, AttemptResetPeriod: time.Minute},
+			exitCode:       0,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       true,
+			expectedReason: "",
+		},
+		{
+			name:           "on-failure does not restart on zero exit code",
+			rp:             RestartPolicy{Name: RestartPolicyNameOnFailure, AttemptResetPeriod: time.Minute},
+			exitCode:       0,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
 			expected:       false,
-			expectedReason: "attempt reset period has not elapsed",
+			expectedReason: "restart policy is on-failure and exit code is 0",
+		},
+		{
+			name:           "on-failure restarts on non-zero exit code",
+			rp:             RestartPolicy{Name: RestartPolicyNameOnFailure, AttemptResetPeriod: time.Minute},
+			exitCode:       1,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       true,
+			expectedReason: "",
+		},
+		{
+			name:           "unless-stopped restarts when not manually stopped",
+			rp:             RestartPolicy{Name: RestartPolicyNameUnlessStopped, AttemptResetPeriod: time.Minute},
+			exitCode:       0,
+			startedAt:      time.Now().Add(-2 * time.Minute),
+			desiredStatus:  apicontainerstatus.ContainerRunning,
+			expected:       true,
+			expectedReason: "",
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			rt.restartPolicy = tc.rp
+			rt.RestartCount = tc.restartCount
 
 			// Because we cannot instantiate int pointers directly,
 			// check for the exit code and leave this int pointer as nil
@@ -113,15 +174,15 @@ func TestShouldRestart(t *testing.T) {
 				exitCodeAdjusted = &tc.exitCode
 			}
 
-			shouldRestart, reason := rt.ShouldRestart(exitCodeAdjusted, tc.startedAt, tc.desiredStatus)
-			assert.Equal(t, tc.expected, shouldRestart)
-			assert.Equal(t, tc.expectedReason, reason)
+			decision := rt.ShouldRestart(exitCodeAdjusted, tc.startedAt, tc.desiredStatus)
+			assert.Equal(t, tc.expected, decision.ShouldRestart)
+			assert.Equal(t, tc.expectedReason, decision.Message)
 		})
 	}
 }
 
 func TestRecordRestart(t *testing.T) {
-	rt := NewRestartTracker(RestartPolicy{Enabled: false, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute})
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameNo, IgnoredExitCodes: []int{0}, AttemptResetPeriod: 1 * time.Minute})
 	assert.Equal(t, 0, rt.RestartCount)
 	for i := 1; i < 1000; i++ {
 		rt.RecordRestart()
@@ -134,3 +195,281 @@ func TestRecordRestartPolicy(t *testing.T) {
 	assert.Equal(t, 0, rt.RestartCount)
 	assert.Equal(t, nil, rt.restartPolicy)
 }
+
+func TestRecordManualStop(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameUnlessStopped, AttemptResetPeriod: time.Minute})
+	assert.False(t, rt.ManuallyStopped)
+
+	exitCode := 0
+	decision := rt.ShouldRestart(&exitCode, time.Now().Add(-2*time.Minute), apicontainerstatus.ContainerRunning)
+	assert.True(t, decision.ShouldRestart)
+	assert.Empty(t, decision.Message)
+
+	rt.RecordManualStop()
+	assert.True(t, rt.ManuallyStopped)
+
+	decision = rt.ShouldRestart(&exitCode, time.Now().Add(-2*time.Minute), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, "container was manually stopped", decision.Message)
+}
+
+func TestRestartPolicyUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name         string
+		json         string
+		expectedName RestartPolicyName
+	}{
+		{
+			name:         "legacy enabled true maps to always",
+			json:         `{"enabled": true}`,
+			expectedName: RestartPolicyNameAlways,
+		},
+		{
+			name:         "legacy enabled false maps to no",
+			json:         `{"enabled": false}`,
+			expectedName: RestartPolicyNameNo,
+		},
+		{
+			name:         "explicit name takes precedence",
+			json:         `{"enabled": false, "name": "on-failure"}`,
+			expectedName: RestartPolicyNameOnFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var rp RestartPolicy
+			assert.NoError(t, json.Unmarshal([]byte(tc.json), &rp))
+			assert.Equal(t, tc.expectedName, rp.Name)
+		})
+	}
+}
+
+func TestRestartPolicyMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name            string
+		rp              RestartPolicy
+		expectedEnabled bool
+	}{
+		{
+			name:            "always sets enabled true",
+			rp:              RestartPolicy{Name: RestartPolicyNameAlways},
+			expectedEnabled: true,
+		},
+		{
+			name:            "on-failure sets enabled true",
+			rp:              RestartPolicy{Name: RestartPolicyNameOnFailure},
+			expectedEnabled: true,
+		},
+		{
+			name:            "no sets enabled false",
+			rp:              RestartPolicy{Name: RestartPolicyNameNo},
+			expectedEnabled: false,
+		},
+		{
+			name:            "empty name sets enabled false",
+			rp:              RestartPolicy{},
+			expectedEnabled: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.rp)
+			assert.NoError(t, err)
+
+			var decoded map[string]interface{}
+			assert.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, tc.expectedEnabled, decoded["enabled"])
+		})
+	}
+}
+
+func TestNextRestartDelay(t *testing.T) {
+	testCases := []struct {
+		name          string
+		rp            RestartPolicy
+		restartCount  int
+		expectedDelay time.Duration
+	}{
+		{
+			name:          "no backoff configured",
+			rp:            RestartPolicy{Name: RestartPolicyNameAlways},
+			restartCount:  2,
+			expectedDelay: 0,
+		},
+		{
+			name:          "doubles with restart count",
+			rp:            RestartPolicy{Name: RestartPolicyNameAlways, BackoffInitial: time.Second},
+			restartCount:  3,
+			expectedDelay: 8 * time.Second,
+		},
+		{
+			name:          "capped at backoff max",
+			rp:            RestartPolicy{Name: RestartPolicyNameAlways, BackoffInitial: time.Second, BackoffMax: 5 * time.Second},
+			restartCount:  3,
+			expectedDelay: 5 * time.Second,
+		},
+		{
+			name:          "large restart count without backoff max does not overflow",
+			rp:            RestartPolicy{Name: RestartPolicyNameAlways, BackoffInitial: time.Second},
+			restartCount:  2000,
+			expectedDelay: maxBackoffDelay,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := NewRestartTracker(tc.rp)
+			rt.RestartCount = tc.restartCount
+			assert.Equal(t, tc.expectedDelay, rt.NextRestartDelay())
+		})
+	}
+}
+
+func TestNextRestartDelayJitter(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{
+		Name:           RestartPolicyNameAlways,
+		BackoffInitial: 10 * time.Second,
+		BackoffMax:     time.Minute,
+		BackoffJitter:  0.25,
+	})
+	rt.RestartCount = 1
+
+	for i := 0; i < 50; i++ {
+		delay := rt.NextRestartDelay()
+		assert.GreaterOrEqual(t, delay, 15*time.Second)
+		assert.LessOrEqual(t, delay, 25*time.Second)
+	}
+}
+
+func TestShouldRestartDecaysRestartCountAfterAttemptResetPeriod(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways, AttemptResetPeriod: time.Minute, MaximumRetryCount: 3})
+	rt.RestartCount = 2
+
+	exitCode := 1
+	decision := rt.ShouldRestart(&exitCode, time.Now().Add(-2*time.Minute), apicontainerstatus.ContainerRunning)
+	assert.True(t, decision.ShouldRestart)
+	assert.Empty(t, decision.Message)
+	assert.Equal(t, 0, rt.RestartCount)
+}
+
+// TestShouldRestartDecaysPastMaximumRetryCountAfterAttemptResetPeriod verifies that a
+// container already at MaximumRetryCount still earns a fresh restart once it has run
+// longer than AttemptResetPeriod, since the decay must be applied before RestartCount is
+// compared against the cap.
+func TestShouldRestartDecaysPastMaximumRetryCountAfterAttemptResetPeriod(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways, AttemptResetPeriod: time.Minute, MaximumRetryCount: 3})
+	rt.RestartCount = 3
+
+	exitCode := 1
+	decision := rt.ShouldRestart(&exitCode, time.Now().Add(-2*time.Minute), apicontainerstatus.ContainerRunning)
+	assert.True(t, decision.ShouldRestart)
+	assert.Empty(t, decision.Message)
+	assert.Equal(t, 0, rt.RestartCount)
+}
+
+// TestShouldRestartFastCrashLoopWithAttemptResetPeriodStillCounts verifies that
+// AttemptResetPeriod never blocks a restart outright: a container stuck in a fast crash
+// loop (each run far shorter than AttemptResetPeriod) must still have RestartCount
+// incremented on every call, and must still be capped by MaximumRetryCount once reached.
+func TestShouldRestartFastCrashLoopWithAttemptResetPeriodStillCounts(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{
+		Name:               RestartPolicyNameAlways,
+		AttemptResetPeriod: 5 * time.Minute,
+		MaximumRetryCount:  10,
+	})
+
+	exitCode := 1
+	for i := 1; i <= 10; i++ {
+		decision := rt.ShouldRestart(&exitCode, time.Now().Add(-time.Second), apicontainerstatus.ContainerRunning)
+		assert.True(t, decision.ShouldRestart, "expected restart %d to be allowed", i)
+		rt.RecordRestart()
+		assert.Equal(t, i, rt.RestartCount)
+	}
+
+	decision := rt.ShouldRestart(&exitCode, time.Now().Add(-time.Second), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, ReasonMaxRetriesExceeded, decision.Reason)
+}
+
+type fakeTerminationNoticeProvider struct {
+	terminating bool
+}
+
+func (f *fakeTerminationNoticeProvider) IsTerminating() bool {
+	return f.terminating
+}
+
+func TestShouldRestartSkipsRestartDuringTermination(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways})
+	provider := &fakeTerminationNoticeProvider{}
+	rt.SetTerminationNoticeProvider(provider)
+
+	exitCode := 1
+	decision := rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.True(t, decision.ShouldRestart)
+	assert.Empty(t, decision.Message)
+
+	provider.terminating = true
+	decision = rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, "instance is terminating", decision.Message)
+	assert.Equal(t, ReasonSpotInterruption, decision.Reason)
+}
+
+func TestShouldRestartUnrecoverableExitCodeIsSticky(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways, UnrecoverableExitCodes: []int{125}})
+
+	exitCode := 125
+	decision := rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, "exit code 125 is unrecoverable", decision.Message)
+	assert.True(t, rt.Unrecoverable)
+
+	// Even a later call with a different, otherwise-restartable exit code must still
+	// short-circuit once the tracker has been marked unrecoverable.
+	otherExitCode := 1
+	decision = rt.ShouldRestart(&otherExitCode, time.Now().Add(-time.Hour), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, "container previously exited with an unrecoverable exit code and will not be restarted", decision.Message)
+	assert.Equal(t, ReasonUnrecoverableError, decision.Reason)
+}
+
+func TestHistory(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways, IgnoredExitCodes: []int{0}})
+	assert.Empty(t, rt.History())
+
+	exitCode := 0
+	decision := rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+
+	history := rt.History()
+	assert.Len(t, history, 1)
+	assert.Equal(t, ReasonExitCodeIgnored, history[0].Reason)
+	assert.Equal(t, decision.Message, history[0].Message)
+	assert.False(t, history[0].ShouldRestart)
+}
+
+func TestHistoryBoundedToMaxSize(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameNo})
+	for i := 0; i < maxRestartDecisionHistory+5; i++ {
+		rt.ShouldRestart(nil, time.Now(), apicontainerstatus.ContainerRunning)
+	}
+	assert.Len(t, rt.History(), maxRestartDecisionHistory)
+}
+
+func TestShouldRestartWaitsForBackoffDelay(t *testing.T) {
+	rt := NewRestartTracker(RestartPolicy{Name: RestartPolicyNameAlways, BackoffInitial: time.Minute})
+	rt.RecordRestart()
+
+	exitCode := 1
+	decision := rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, "waiting for backoff delay", decision.Message)
+
+	rt.lastRestartAt = time.Now().Add(-5 * time.Minute)
+	decision = rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.True(t, decision.ShouldRestart)
+	assert.Empty(t, decision.Message)
+}
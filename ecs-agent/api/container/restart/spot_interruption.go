@@ -0,0 +1,142 @@
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package restart
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	imdsTokenEndpoint       = "http://169.254.169.254/latest/api/token"
+	imdsSpotActionEndpoint  = "http://169.254.169.254/latest/meta-data/spot/instance-action"
+	imdsTokenTTLHeader      = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsTokenHeader         = "X-aws-ec2-metadata-token"
+	imdsTokenTTL            = "21600"
+	defaultSpotPollInterval = 5 * time.Second
+	imdsRequestTimeout      = 5 * time.Second
+)
+
+// SpotInterruptionPoller implements TerminationNoticeProvider by periodically polling the
+// IMDS spot instance-action endpoint for a pending Spot interruption notice, caching the
+// result so RestartTracker.ShouldRestart doesn't make a network call on every exit.
+type SpotInterruptionPoller struct {
+	client       *http.Client
+	pollInterval time.Duration
+	// tokenEndpoint and spotActionEndpoint are overridden in tests to point at a fake
+	// IMDS server instead of the real link-local address.
+	tokenEndpoint      string
+	spotActionEndpoint string
+
+	mu          sync.RWMutex
+	terminating bool
+}
+
+// NewSpotInterruptionPoller returns a SpotInterruptionPoller that checks IMDS for a pending
+// Spot interruption every pollInterval. A pollInterval <= 0 defaults to 5 seconds.
+func NewSpotInterruptionPoller(pollInterval time.Duration) *SpotInterruptionPoller {
+	if pollInterval <= 0 {
+		pollInterval = defaultSpotPollInterval
+	}
+	return &SpotInterruptionPoller{
+		client:             &http.Client{Timeout: imdsRequestTimeout},
+		pollInterval:       pollInterval,
+		tokenEndpoint:      imdsTokenEndpoint,
+		spotActionEndpoint: imdsSpotActionEndpoint,
+	}
+}
+
+// Start polls IMDS in a loop until ctx is cancelled. It is expected to be run in its own
+// goroutine by the agent at startup.
+func (p *SpotInterruptionPoller) Start(ctx context.Context) {
+	p.poll()
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// IsTerminating returns whether the most recent poll observed a pending Spot interruption.
+func (p *SpotInterruptionPoller) IsTerminating() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.terminating
+}
+
+func (p *SpotInterruptionPoller) poll() {
+	terminating := p.hasPendingSpotInterruption()
+	p.mu.Lock()
+	p.terminating = terminating
+	p.mu.Unlock()
+}
+
+// hasPendingSpotInterruption fetches an IMDSv2 token and uses it to check the spot
+// instance-action endpoint, which only returns 200 once an interruption has been
+// scheduled. Any error talking to IMDS is treated as "not terminating".
+func (p *SpotInterruptionPoller) hasPendingSpotInterruption() bool {
+	token, err := p.fetchToken()
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest(http.MethodGet, p.spotActionEndpoint, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set(imdsTokenHeader, token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
+
+// NewRestartTrackerWithSpotInterruption returns a RestartTracker wired to poller as its
+// TerminationNoticeProvider, starting poller in its own goroutine until ctx is cancelled.
+// This is the default path agent startup should use instead of wiring
+// SetTerminationNoticeProvider and Start separately.
+func NewRestartTrackerWithSpotInterruption(ctx context.Context, restartPolicy RestartPolicy, poller *SpotInterruptionPoller) *RestartTracker {
+	rt := NewRestartTracker(restartPolicy)
+	rt.SetTerminationNoticeProvider(poller)
+	go poller.Start(ctx)
+	return rt
+}
+
+func (p *SpotInterruptionPoller) fetchToken() (string, error) {
+	req, err := http.NewRequest(http.MethodPut, p.tokenEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(imdsTokenTTLHeader, imdsTokenTTL)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
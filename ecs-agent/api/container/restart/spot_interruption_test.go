@@ -0,0 +1,117 @@
+//go:build unit
+// +build unit
+
+// Copyright Amazon.com Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package restart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apicontainerstatus "github.com/aws/amazon-ecs-agent/ecs-agent/api/container/status"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIMDSServer(t *testing.T, spotActionStatus int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("test-token"))
+	})
+	mux.HandleFunc("/latest/meta-data/spot/instance-action", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-token", r.Header.Get(imdsTokenHeader))
+		w.WriteHeader(spotActionStatus)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestSpotInterruptionPollerDefaultsPollInterval(t *testing.T) {
+	p := NewSpotInterruptionPoller(0)
+	assert.Equal(t, defaultSpotPollInterval, p.pollInterval)
+}
+
+func TestSpotInterruptionPollerIsTerminatingDefaultsFalse(t *testing.T) {
+	p := NewSpotInterruptionPoller(time.Second)
+	assert.False(t, p.IsTerminating())
+}
+
+func TestHasPendingSpotInterruption(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		expected bool
+	}{
+		{name: "no interruption scheduled", status: http.StatusNotFound, expected: false},
+		{name: "interruption scheduled", status: http.StatusOK, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestIMDSServer(t, tc.status)
+			defer server.Close()
+
+			p := NewSpotInterruptionPoller(time.Second)
+			p.tokenEndpoint = server.URL + "/latest/api/token"
+			p.spotActionEndpoint = server.URL + "/latest/meta-data/spot/instance-action"
+
+			assert.Equal(t, tc.expected, p.hasPendingSpotInterruption())
+		})
+	}
+}
+
+func TestSpotInterruptionPollerHasPendingSpotInterruptionFailsClosed(t *testing.T) {
+	p := NewSpotInterruptionPoller(time.Second)
+	p.tokenEndpoint = "http://127.0.0.1:0/latest/api/token"
+	p.spotActionEndpoint = "http://127.0.0.1:0/latest/meta-data/spot/instance-action"
+
+	assert.False(t, p.hasPendingSpotInterruption())
+}
+
+func TestSpotInterruptionPollerPoll(t *testing.T) {
+	server := newTestIMDSServer(t, http.StatusOK)
+	defer server.Close()
+
+	p := NewSpotInterruptionPoller(time.Second)
+	p.tokenEndpoint = server.URL + "/latest/api/token"
+	p.spotActionEndpoint = server.URL + "/latest/meta-data/spot/instance-action"
+
+	assert.False(t, p.IsTerminating())
+	p.poll()
+	assert.True(t, p.IsTerminating())
+}
+
+func TestNewRestartTrackerWithSpotInterruption(t *testing.T) {
+	server := newTestIMDSServer(t, http.StatusOK)
+	defer server.Close()
+
+	poller := NewSpotInterruptionPoller(time.Millisecond)
+	poller.tokenEndpoint = server.URL + "/latest/api/token"
+	poller.spotActionEndpoint = server.URL + "/latest/meta-data/spot/instance-action"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rt := NewRestartTrackerWithSpotInterruption(ctx, RestartPolicy{Name: RestartPolicyNameAlways}, poller)
+	assert.Eventually(t, poller.IsTerminating, time.Second, time.Millisecond)
+
+	exitCode := 1
+	decision := rt.ShouldRestart(&exitCode, time.Now(), apicontainerstatus.ContainerRunning)
+	assert.False(t, decision.ShouldRestart)
+	assert.Equal(t, ReasonSpotInterruption, decision.Reason)
+}